@@ -39,9 +39,13 @@ type LeafData struct {
 }
 
 // LeafHash concats and hashes all the data in LeafData.
+//
+// NOTE: This always hashes the version 1 serialization regardless of which
+// LeafDataVersion Serialize currently defaults to, so that upgrading the
+// on-disk/wire format never changes an existing accumulator commitment.
 func (l *LeafData) LeafHash() [32]byte {
 	digest := sha512.New512_256()
-	l.Serialize(digest)
+	l.serializeV1(digest)
 
 	// TODO go 1.17 support slice to array conversion so we
 	// can avoid this extra copy.
@@ -65,10 +69,13 @@ func (l *LeafData) ToString() (s string) {
 
 // -----------------------------------------------------------------------------
 // LeafData serialization includes all the data needed for generating the hash
-// commitment of the LeafData.
+// commitment of the LeafData.  It's prefixed with a LeafDataVersion byte so
+// that on-disk/wire bodies written by older code can still be told apart from
+// the current one; see LeafDataVersion for what each version's body looks
+// like.
 //
 // The serialized format is:
-// [<block hash><outpoint><stxo>]
+// [<version><block hash><outpoint><stxo>]
 //
 // The outpoint serialized format is:
 // [<tx hash><index>]
@@ -89,6 +96,7 @@ func (l *LeafData) ToString() (s string) {
 // All together, the serialization looks like so:
 //
 // Field              Type       Size
+// version            VLQ        variable
 // block hash         [32]byte   32
 // outpoint           -          33-36
 //   tx hash          [32]byte   32
@@ -102,30 +110,69 @@ func (l *LeafData) ToString() (s string) {
 // -----------------------------------------------------------------------------
 
 // SerializeSize returns the number of bytes it would take to serialize the
-// LeafData.
+// LeafData using LatestLeafDataVersion.
 func (l *LeafData) SerializeSize() int {
+	// version byte + the version 2 body.
+	return 1 + l.serializeSizeV2()
+}
+
+// Serialize encodes the LeafData to w, prefixed with a LeafDataVersion byte
+// identifying the body that follows.  It always writes LatestLeafDataVersion;
+// Deserialize understands every version below it so a store can be migrated
+// one entry at a time with MigrateLeafData rather than all at once.
+func (l *LeafData) Serialize(w io.Writer) error {
+	if err := WriteVarInt(w, 0, uint64(LatestLeafDataVersion)); err != nil {
+		return err
+	}
+	return l.serializeV2(w)
+}
+
+// Deserialize decodes the LeafData from r, dispatching on the leading
+// LeafDataVersion byte written by Serialize.  It returns
+// ErrUnknownLeafDataVersion for any version it doesn't recognize.
+func (l *LeafData) Deserialize(r io.Reader) error {
+	version, err := ReadVarInt(r, 0)
+	if err != nil {
+		return err
+	}
+
+	switch LeafDataVersion(version) {
+	case LeafDataVersion1:
+		return l.deserializeV1(r)
+	case LeafDataVersion2:
+		return l.deserializeV2(r)
+	default:
+		return ErrUnknownLeafDataVersion
+	}
+}
+
+// serializeSizeV1 returns the number of bytes it would take to serialize the
+// LeafData using the version 1 body (no BlockHash).
+func (l *LeafData) serializeSizeV1() int {
 	var size int
 	size += VarIntSerializeSize(uint64(l.OutPoint.Index))
 	size += VarIntSerializeSize(uint64(l.Height))
 	size += VarIntSerializeSize(uint64(l.Amount))
 	size += VarIntSerializeSize(uint64(len(l.PkScript)))
 
-	// blockhash + txhash + pkscript size + others
-	return chainhash.HashSize + chainhash.HashSize + len(l.PkScript) + size
+	// txhash + pkscript size + others
+	return chainhash.HashSize + len(l.PkScript) + size
 }
 
-// Serialize encodes the LeafData to w using the LeafData serialization format.
-func (l *LeafData) Serialize(w io.Writer) error {
+// serializeV1 encodes the LeafData to w using the version 1 body: no
+// BlockHash, with OutPoint and an uncompressed stxo.  This is the original
+// LeafData.Serialize behavior, kept unexported and unchanged so that
+// LeafHash's accumulator commitment never shifts out from under it.
+func (l *LeafData) serializeV1(w io.Writer) error {
+	if l.OutPoint == nil {
+		return messageError("LeafData.serializeV1", "OutPoint must be set")
+	}
+
 	hcb := l.Height << 1
 	if l.IsCoinBase {
 		hcb |= 1
 	}
 
-	// TODO Add the Blockhash back in.
-	//_, err := w.Write(l.BlockHash[:])
-	//if err != nil {
-	//	return err
-	//}
 	var hash chainhash.Hash
 	if bytes.Equal(l.OutPoint.Hash[:], hash[:]) {
 		panic("l.OutPoint.Hash == hash")
@@ -147,20 +194,16 @@ func (l *LeafData) Serialize(w io.Writer) error {
 		return err
 	}
 	if uint32(len(l.PkScript)) > MaxScriptSize {
-		return messageError("LeafData.Serialize", "pkScript too long")
+		return messageError("LeafData.serializeV1", "pkScript too long")
 	}
 
 	return WriteVarBytes(w, 0, l.PkScript)
 }
 
-// Deserialize encodes the LeafData from r using the LeafData serialization format.
-func (l *LeafData) Deserialize(r io.Reader) error {
-	// TODO Deserialize the blockhash.
-	//l.BlockHash = new(chainhash.Hash)
-	//_, err := io.ReadFull(r, l.BlockHash[:])
-	//if err != nil {
-	//	return err
-	//}
+// deserializeV1 decodes the LeafData from r using the version 1 body (no
+// BlockHash).
+func (l *LeafData) deserializeV1(r io.Reader) error {
+	l.BlockHash = nil
 
 	// Deserialize the outpoint.
 	l.OutPoint = &OutPoint{Hash: *(new(chainhash.Hash)), Index: 0}
@@ -201,14 +244,50 @@ func (l *LeafData) Deserialize(r io.Reader) error {
 	return nil
 }
 
+// serializeSizeV2 returns the number of bytes it would take to serialize the
+// LeafData using the version 2 body (BlockHash restored).
+func (l *LeafData) serializeSizeV2() int {
+	// blockhash + the version 1 body.
+	return chainhash.HashSize + l.serializeSizeV1()
+}
+
+// serializeV2 encodes the LeafData to w using the version 2 body: the
+// version 1 body with BlockHash restored to the front, fixing the omission
+// version 1 shipped with.
+func (l *LeafData) serializeV2(w io.Writer) error {
+	if l.BlockHash == nil {
+		return messageError("LeafData.serializeV2", "BlockHash must be set")
+	}
+	if _, err := w.Write(l.BlockHash[:]); err != nil {
+		return err
+	}
+	return l.serializeV1(w)
+}
+
+// deserializeV2 decodes the LeafData from r using the version 2 body
+// (BlockHash restored).
+func (l *LeafData) deserializeV2(r io.Reader) error {
+	l.BlockHash = new(chainhash.Hash)
+	if _, err := io.ReadFull(r, l.BlockHash[:]); err != nil {
+		return err
+	}
+	return l.deserializeV1(r)
+}
+
 // -----------------------------------------------------------------------------
 // Compact LeafData serialization leaves out duplicate data that is also present
 // in the Bitcoin block.  It's important to note that to genereate the hash
 // commitment for the LeafData, there data left out from the compact serialization
 // is still needed and must be fetched from the Bitcoin block.
 //
+// Like the full serialization, it's prefixed with a LeafDataVersion byte; the
+// compact body is only ever written in its version 1 (uncompressed) shape, so
+// the byte is always LeafDataVersion1, but it's still checked on the way in
+// so an attempt to decompact a buffer from a newer format fails loudly
+// instead of silently misreading it.
+//
 // The serialized format is:
-// [<stxo>]
+// [<version><stxo>]
 //
 // The serialized header code format is:
 //   bit 0 - containing transaction is a coinbase
@@ -221,6 +300,7 @@ func (l *LeafData) Deserialize(r io.Reader) error {
 //   }
 //
 // Field              Type       Size
+// version            VLQ        variable
 // stxo               -          variable
 //   header code      VLQ        variable
 //   amount           VLQ        variable
@@ -237,6 +317,7 @@ func (l *LeafData) SerializeSizeCompact() int {
 	if l.IsCoinBase {
 		hcb |= 1
 	}
+	size += VarIntSerializeSize(uint64(LeafDataVersion1))
 	size += VarIntSerializeSize(uint64(hcb))
 	size += VarIntSerializeSize(uint64(l.Amount))
 	size += VarIntSerializeSize(uint64(len(l.PkScript)))
@@ -251,6 +332,10 @@ func (l *LeafData) SerializeCompact(w io.Writer) error {
 		hcb |= 1
 	}
 
+	if err := WriteVarInt(w, 0, uint64(LeafDataVersion1)); err != nil {
+		return err
+	}
+
 	// Height & IsCoinBase.
 	err := WriteVarInt(w, 0, uint64(hcb))
 	if err != nil {
@@ -270,6 +355,14 @@ func (l *LeafData) SerializeCompact(w io.Writer) error {
 
 // DeserializeCompact encodes the LeafData to w using the compact leaf serialization format.
 func (l *LeafData) DeserializeCompact(r io.Reader) error {
+	version, err := ReadVarInt(r, 0)
+	if err != nil {
+		return err
+	}
+	if LeafDataVersion(version) != LeafDataVersion1 {
+		return ErrUnknownLeafDataVersion
+	}
+
 	height, err := ReadVarInt(r, 0)
 	if err != nil {
 		return err
@@ -0,0 +1,438 @@
+// Copyright (c) 2021 The utreexo developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"io"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// -----------------------------------------------------------------------------
+// In order to reduce the size of LeafData on disk and on the wire, both the
+// Amount and the PkScript are compressed using the same domain-specific
+// compression btcd applies to its pruned UTXO set.  The resulting values are
+// NOT used anywhere the exact original bytes must round-trip through the
+// accumulator commitment -- LeafHash always hashes the uncompressed
+// serialization so compressing a leaf never changes its accumulator hash.
+// -----------------------------------------------------------------------------
+
+// compressTxOutAmount compresses the passed amount according to the domain
+// specific compression algorithm described above.
+func compressTxOutAmount(amount uint64) uint64 {
+	// No need to do any work if it's zero.
+	if amount == 0 {
+		return 0
+	}
+
+	// Find the largest power of 10 (max of 9) that evenly divides the
+	// value.
+	exponent := uint64(0)
+	for amount%10 == 0 && exponent < 9 {
+		amount /= 10
+		exponent++
+	}
+
+	// The compressed result is one of the following two equations:
+	//   n = 1 + 10*(9*d + (lastDigit-1)) + e  .. exponent e is < 9
+	//   n = 1 + 10*(d-1)                 + 9  .. exponent e is a 9
+	if exponent < 9 {
+		lastDigit := amount % 10
+		d := amount / 10
+		return 1 + 10*(9*d+(lastDigit-1)) + exponent
+	}
+	return 1 + 10*(amount-1) + 9
+}
+
+// decompressTxOutAmount returns the original amount the passed amount was
+// compressed from using the domain specific compression algorithm described
+// above.
+func decompressTxOutAmount(amount uint64) uint64 {
+	// No need to do any work if it's zero.
+	if amount == 0 {
+		return 0
+	}
+
+	// The decompressed amount is either of the following two equations:
+	//   x = (10*(m/9) + m%9 + 1) * 10^e  .. e < 9, where m = (n-1-e)/10
+	//   x = ((n-1-9)/10 + 1)     * 10^9  .. e = 9
+	amount--
+
+	// Decode the exponent.
+	exponent := amount % 10
+	amount /= 10
+
+	var n uint64
+	if exponent < 9 {
+		lastDigit := amount%9 + 1
+		amount /= 9
+		n = amount*10 + lastDigit
+	} else {
+		n = amount + 1
+	}
+
+	for ; exponent > 0; exponent-- {
+		n *= 10
+	}
+
+	return n
+}
+
+// The following constants specify the special constants used to identify a
+// special script type in the domain-specific compressed script encoding.
+//
+// NOTE: This package cannot import package txscript due to a dependency
+// cycle (see the MaxScriptSize comment in leaf.go), so the handful of standard
+// script templates recognized here are matched against raw bytes instead of
+// using txscript's script classification.
+const (
+	// cstPayToPubKeyHash identifies a compressed pay-to-pubkey-hash script.
+	cstPayToPubKeyHash = 0
+
+	// cstPayToScriptHash identifies a compressed pay-to-script-hash script.
+	cstPayToScriptHash = 1
+
+	// cstPayToPubKeyComp0 identifies a compressed pay-to-pubkey script with
+	// a leading 0x02 byte.
+	cstPayToPubKeyComp0 = 2
+
+	// cstPayToPubKeyComp1 identifies a compressed pay-to-pubkey script with
+	// a leading 0x03 byte.
+	cstPayToPubKeyComp1 = 3
+
+	// cstPayToPubKeyUncomp0 identifies an uncompressed pay-to-pubkey script
+	// whose pubkey recompresses to a leading 0x02 byte.
+	cstPayToPubKeyUncomp0 = 4
+
+	// cstPayToPubKeyUncomp1 identifies an uncompressed pay-to-pubkey script
+	// whose pubkey recompresses to a leading 0x03 byte.
+	cstPayToPubKeyUncomp1 = 5
+
+	// numSpecialScripts is the number of special scripts recognized by the
+	// domain-specific script compression algorithm.  Values below this in
+	// the leading VLQ identify one of the templates above; values at or
+	// above it carry a raw, non-standard script biased by this amount.
+	numSpecialScripts = 6
+)
+
+// isPubKeyHash returns whether or not the passed public key script is a
+// standard pay-to-pubkey-hash script.
+func isPubKeyHash(script []byte) bool {
+	return len(script) == 25 &&
+		script[0] == opDup &&
+		script[1] == opHash160 &&
+		script[2] == opData20 &&
+		script[23] == opEqualVerify &&
+		script[24] == opCheckSig
+}
+
+// isScriptHash returns whether or not the passed public key script is a
+// standard pay-to-script-hash script.
+func isScriptHash(script []byte) bool {
+	return len(script) == 23 &&
+		script[0] == opHash160 &&
+		script[1] == opData20 &&
+		script[22] == opEqual
+}
+
+// isPubKeyComp returns whether or not the passed public key script is a
+// standard pay-to-pubkey script using a compressed public key.
+func isPubKeyComp(script []byte) bool {
+	return len(script) == 35 &&
+		script[0] == opData33 &&
+		script[34] == opCheckSig &&
+		(script[1] == 0x02 || script[1] == 0x03)
+}
+
+// isPubKeyUncomp returns whether or not the passed public key script is a
+// standard pay-to-pubkey script using an uncompressed public key.
+func isPubKeyUncomp(script []byte) bool {
+	return len(script) == 67 &&
+		script[0] == opData65 &&
+		script[66] == opCheckSig &&
+		script[1] == 0x04
+}
+
+// Raw opcodes needed to recognize and rebuild the standard script templates
+// above without importing package txscript.
+const (
+	opDup         = 0x76
+	opEqual       = 0x87
+	opEqualVerify = 0x88
+	opHash160     = 0xa9
+	opCheckSig    = 0xac
+	opData20      = 0x14
+	opData33      = 0x21
+	opData65      = 0x41
+)
+
+// payToPubKeyHashScript builds a standard pay-to-pubkey-hash script from the
+// given 20-byte hash.
+func payToPubKeyHashScript(hash []byte) []byte {
+	script := make([]byte, 0, 25)
+	script = append(script, opDup, opHash160, opData20)
+	script = append(script, hash...)
+	script = append(script, opEqualVerify, opCheckSig)
+	return script
+}
+
+// payToScriptHashScript builds a standard pay-to-script-hash script from the
+// given 20-byte hash.
+func payToScriptHashScript(hash []byte) []byte {
+	script := make([]byte, 0, 23)
+	script = append(script, opHash160, opData20)
+	script = append(script, hash...)
+	script = append(script, opEqual)
+	return script
+}
+
+// payToPubKeyScript builds a standard pay-to-pubkey script from the given
+// serialized public key (either compressed or uncompressed).
+func payToPubKeyScript(pubKey []byte) []byte {
+	var pushOp byte
+	if len(pubKey) == 33 {
+		pushOp = opData33
+	} else {
+		pushOp = opData65
+	}
+	script := make([]byte, 0, len(pubKey)+2)
+	script = append(script, pushOp)
+	script = append(script, pubKey...)
+	script = append(script, opCheckSig)
+	return script
+}
+
+// compressedScriptEncoding returns the VLQ tag/size that identifies how
+// pkScript is encoded in the compressed serialization along with the payload
+// bytes that follow it.  Standard script templates are identified with one
+// of the special tags in the 0..5 range and reduced to their minimal payload;
+// any other script is written in full, with its length biased by
+// numSpecialScripts so the special tag namespace is never ambiguous with a
+// real length.
+func compressedScriptEncoding(pkScript []byte) (uint64, []byte) {
+	switch {
+	case isPubKeyHash(pkScript):
+		return cstPayToPubKeyHash, pkScript[3:23]
+
+	case isScriptHash(pkScript):
+		return cstPayToScriptHash, pkScript[2:22]
+
+	case isPubKeyComp(pkScript):
+		tag := uint64(cstPayToPubKeyComp0)
+		if pkScript[1] == 0x03 {
+			tag = cstPayToPubKeyComp1
+		}
+		return tag, pkScript[2:34]
+
+	case isPubKeyUncomp(pkScript):
+		pubKey, err := btcec.ParsePubKey(pkScript[1:66])
+		if err == nil {
+			compressed := pubKey.SerializeCompressed()
+			tag := uint64(cstPayToPubKeyUncomp0)
+			if compressed[0] == 0x03 {
+				tag = cstPayToPubKeyUncomp1
+			}
+			return tag, compressed[1:33]
+		}
+	}
+
+	return uint64(len(pkScript)) + numSpecialScripts, pkScript
+}
+
+// compressedScriptSize returns the number of bytes compressedScriptEncoding
+// would write for pkScript, including the leading VLQ.
+func compressedScriptSize(pkScript []byte) int {
+	tagOrSize, payload := compressedScriptEncoding(pkScript)
+	return VarIntSerializeSize(tagOrSize) + len(payload)
+}
+
+// writeCompressedScript writes pkScript to w using the domain-specific
+// compressed script encoding.
+func writeCompressedScript(w io.Writer, pkScript []byte) error {
+	if uint32(len(pkScript)) > MaxScriptSize {
+		return messageError("writeCompressedScript", "pkScript too long")
+	}
+
+	tagOrSize, payload := compressedScriptEncoding(pkScript)
+	if err := WriteVarInt(w, 0, tagOrSize); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readCompressedScript reads a compressed script from r and decompresses it
+// back to its original, standalone form.
+func readCompressedScript(r io.Reader) ([]byte, error) {
+	tagOrSize, err := ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	switch tagOrSize {
+	case cstPayToPubKeyHash:
+		var hash [20]byte
+		if _, err := io.ReadFull(r, hash[:]); err != nil {
+			return nil, err
+		}
+		return payToPubKeyHashScript(hash[:]), nil
+
+	case cstPayToScriptHash:
+		var hash [20]byte
+		if _, err := io.ReadFull(r, hash[:]); err != nil {
+			return nil, err
+		}
+		return payToScriptHashScript(hash[:]), nil
+
+	case cstPayToPubKeyComp0, cstPayToPubKeyComp1:
+		var compressed [33]byte
+		compressed[0] = 0x02
+		if tagOrSize == cstPayToPubKeyComp1 {
+			compressed[0] = 0x03
+		}
+		if _, err := io.ReadFull(r, compressed[1:]); err != nil {
+			return nil, err
+		}
+		return payToPubKeyScript(compressed[:]), nil
+
+	case cstPayToPubKeyUncomp0, cstPayToPubKeyUncomp1:
+		var compressed [33]byte
+		compressed[0] = 0x02
+		if tagOrSize == cstPayToPubKeyUncomp1 {
+			compressed[0] = 0x03
+		}
+		if _, err := io.ReadFull(r, compressed[1:]); err != nil {
+			return nil, err
+		}
+		pubKey, err := btcec.ParsePubKey(compressed[:])
+		if err != nil {
+			return nil, err
+		}
+		return payToPubKeyScript(pubKey.SerializeUncompressed()), nil
+
+	default:
+		size := tagOrSize - numSpecialScripts
+		if size > MaxScriptSize {
+			return nil, messageError("readCompressedScript", "pkScript size too long")
+		}
+		pkScript := make([]byte, size)
+		if _, err := io.ReadFull(r, pkScript); err != nil {
+			return nil, err
+		}
+		return pkScript, nil
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Compressed LeafData serialization applies the domain-specific compression
+// above to the Amount and PkScript fields, on top of the same layout used by
+// the compact serialization.  It's meant for long-term disk storage of the
+// utreexo proof store, where the ~99% of outputs using a standard script
+// template shrink considerably.
+//
+// It's prefixed with a LeafDataVersion byte just like the full and compact
+// serializations; the compressed body is only ever written in its version 3
+// shape, so the byte is always LeafDataVersion3, and DeserializeCompressed
+// rejects anything else rather than misreading an incompatible body.
+//
+// The serialized format is:
+// [<version><stxo>]
+//
+// Field              Type       Size
+// version            VLQ        variable
+// stxo               -          variable
+//   header code      VLQ        variable
+//   compressed amount VLQ        variable
+//   compressed script -          variable
+//
+// -----------------------------------------------------------------------------
+
+// SerializeSizeCompressed returns the number of bytes it would take to
+// serialize the LeafData in the compressed serialization format.
+func (l *LeafData) SerializeSizeCompressed() int {
+	return VarIntSerializeSize(uint64(LeafDataVersion3)) + l.serializeSizeV3()
+}
+
+// SerializeCompressed encodes the LeafData to w using the compressed leaf
+// data serialization format.
+func (l *LeafData) SerializeCompressed(w io.Writer) error {
+	if err := WriteVarInt(w, 0, uint64(LeafDataVersion3)); err != nil {
+		return err
+	}
+	return l.serializeV3(w)
+}
+
+// DeserializeCompressed decodes the LeafData from r using the compressed
+// leaf data serialization format.
+func (l *LeafData) DeserializeCompressed(r io.Reader) error {
+	version, err := ReadVarInt(r, 0)
+	if err != nil {
+		return err
+	}
+	if LeafDataVersion(version) != LeafDataVersion3 {
+		return ErrUnknownLeafDataVersion
+	}
+
+	return l.deserializeV3(r)
+}
+
+// serializeSizeV3 returns the number of bytes it would take to serialize the
+// LeafData using the version 3 body (compressed stxo, no BlockHash).
+func (l *LeafData) serializeSizeV3() int {
+	hcb := l.Height << 1
+	if l.IsCoinBase {
+		hcb |= 1
+	}
+
+	size := VarIntSerializeSize(uint64(hcb))
+	size += VarIntSerializeSize(compressTxOutAmount(uint64(l.Amount)))
+	size += compressedScriptSize(l.PkScript)
+
+	return size
+}
+
+// serializeV3 encodes the LeafData to w using the version 3 body: the
+// compact body's layout with Amount/PkScript run through the domain-specific
+// compression above.
+func (l *LeafData) serializeV3(w io.Writer) error {
+	hcb := l.Height << 1
+	if l.IsCoinBase {
+		hcb |= 1
+	}
+
+	if err := WriteVarInt(w, 0, uint64(hcb)); err != nil {
+		return err
+	}
+
+	if err := WriteVarInt(w, 0, compressTxOutAmount(uint64(l.Amount))); err != nil {
+		return err
+	}
+
+	return writeCompressedScript(w, l.PkScript)
+}
+
+// deserializeV3 decodes the LeafData from r using the version 3 body
+// (compressed stxo, no BlockHash).
+func (l *LeafData) deserializeV3(r io.Reader) error {
+	height, err := ReadVarInt(r, 0)
+	if err != nil {
+		return err
+	}
+	l.Height = int32(height)
+
+	if l.Height&1 == 1 {
+		l.IsCoinBase = true
+	}
+	l.Height >>= 1
+
+	amt, err := ReadVarInt(r, 0)
+	if err != nil {
+		return err
+	}
+	l.Amount = int64(decompressTxOutAmount(amt))
+
+	l.PkScript, err = readCompressedScript(r)
+	return err
+}
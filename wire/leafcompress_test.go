@@ -0,0 +1,118 @@
+// Copyright (c) 2021 The utreexo developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// mustHexDecode decodes a hex string, panicking on error.  It's only used
+// for fixed test fixtures.
+func mustHexDecode(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// TestTxOutAmountCompression ensures compressTxOutAmount/decompressTxOutAmount
+// round-trip a representative set of amounts, including the exponent-9 edge
+// case and the largest possible bitcoin amount.
+func TestTxOutAmountCompression(t *testing.T) {
+	tests := []uint64{
+		0, 1, 2, 9, 10, 100, 12345, 54321,
+		100000000, 999999999, 1000000000,
+		5000000000, 21000000 * 100000000,
+	}
+
+	for _, amount := range tests {
+		compressed := compressTxOutAmount(amount)
+		got := decompressTxOutAmount(compressed)
+		if got != amount {
+			t.Errorf("compress/decompress round-trip failed for amount %d: "+
+				"compressed to %d, decompressed to %d", amount, compressed, got)
+		}
+	}
+}
+
+// TestLeafDataCompressedRoundTrip checks SerializeCompressed/DeserializeCompressed
+// round-trip a corpus of mainnet-style pkscript templates: P2PKH, P2SH,
+// compressed P2PK, uncompressed P2PK, and a handful of non-standard scripts.
+func TestLeafDataCompressedRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		pkScript []byte
+	}{
+		{
+			name: "p2pkh",
+			pkScript: append(append([]byte{opDup, opHash160, opData20},
+				bytes.Repeat([]byte{0x11}, 20)...), opEqualVerify, opCheckSig),
+		},
+		{
+			name: "p2sh",
+			pkScript: append(append([]byte{opHash160, opData20},
+				bytes.Repeat([]byte{0x22}, 20)...), opEqual),
+		},
+		{
+			name: "p2pk-compressed-even",
+			pkScript: append(append([]byte{opData33, 0x02},
+				bytes.Repeat([]byte{0x33}, 32)...), opCheckSig),
+		},
+		{
+			name: "p2pk-compressed-odd",
+			pkScript: append(append([]byte{opData33, 0x03},
+				bytes.Repeat([]byte{0x44}, 32)...), opCheckSig),
+		},
+		{
+			// secp256k1 generator point, uncompressed -- a valid curve
+			// point so the compressed-pubkey recovery path is exercised.
+			name: "p2pk-uncompressed",
+			pkScript: append(append([]byte{opData65, 0x04},
+				mustHexDecode("79BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798"+
+					"483ADA7726A3C4655DA4FBFC0E1108A8FD17B448A68554199C47D08FFB10D4B8")...), opCheckSig),
+		},
+		{
+			name:     "non-standard-empty",
+			pkScript: []byte{},
+		},
+		{
+			name:     "non-standard-opreturn",
+			pkScript: append([]byte{0x6a, 0x04}, []byte("test")...),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			leaf := LeafData{
+				Height:     123456,
+				IsCoinBase: false,
+				Amount:     5000000000,
+				PkScript:   test.pkScript,
+			}
+
+			var buf bytes.Buffer
+			if err := leaf.SerializeCompressed(&buf); err != nil {
+				t.Fatalf("SerializeCompressed: %v", err)
+			}
+
+			if got, want := buf.Len(), leaf.SerializeSizeCompressed(); got != want {
+				t.Errorf("SerializeSizeCompressed mismatch: got %d, want %d", got, want)
+			}
+
+			var got LeafData
+			if err := got.DeserializeCompressed(&buf); err != nil {
+				t.Fatalf("DeserializeCompressed: %v", err)
+			}
+
+			if got.Height != leaf.Height || got.IsCoinBase != leaf.IsCoinBase ||
+				got.Amount != leaf.Amount || !bytes.Equal(got.PkScript, leaf.PkScript) {
+				t.Errorf("round-trip mismatch: got %+v, want %+v", got, leaf)
+			}
+		})
+	}
+}
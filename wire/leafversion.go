@@ -0,0 +1,148 @@
+// Copyright (c) 2021 The utreexo developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// LeafDataVersion identifies the body written after the leading version byte
+// in LeafData's various serializations.  It's analogous to the
+// latestUtxoSetBucketVersion btcd's chainio keeps for its UTXO set bucket.
+type LeafDataVersion uint8
+
+const (
+	// LeafDataVersion1 is the original LeafData body: no BlockHash, and an
+	// uncompressed stxo (Amount/PkScript).  Kept around for backwards
+	// compatibility with databases written before BlockHash and compression
+	// support were added; it's still the only body SerializeCompact writes.
+	LeafDataVersion1 LeafDataVersion = 1
+
+	// LeafDataVersion2 restores the BlockHash field that version 1 omitted,
+	// otherwise identical to version 1.  It's the body Serialize writes.
+	LeafDataVersion2 LeafDataVersion = 2
+
+	// LeafDataVersion3 is the compressed stxo body written by
+	// SerializeCompressed: no BlockHash, with Amount/PkScript run through
+	// the domain-specific compression in leafcompress.go.
+	LeafDataVersion3 LeafDataVersion = 3
+
+	// LatestLeafDataVersion is the version Serialize writes.
+	LatestLeafDataVersion = LeafDataVersion2
+)
+
+// ErrUnknownLeafDataVersion is returned when a LeafData deserialization
+// routine encounters a version byte it doesn't recognize.
+var ErrUnknownLeafDataVersion = errors.New("unknown LeafData version")
+
+// MigrateLeafData re-encodes a single LeafData body from oldVersion's shape
+// to newVersion's shape, modeled on btcd's migrateBlockIndex.  r and w carry
+// the bare body for their respective version -- neither includes the leading
+// version byte Serialize/Deserialize write, since the caller (typically
+// MigrateLeafDataBatch, driven against an on-disk bucket keyed by version) is
+// the one that knows whether that byte is present on disk and owns rewriting
+// it once the body below it has been migrated.
+//
+// Not every version pair can be migrated without help: upgrading to
+// LeafDataVersion2 requires a BlockHash, which isn't recoverable from a
+// LeafDataVersion1 or LeafDataVersion3 body.  blockHash lets the caller
+// supply one looked up out of band (e.g. from the block that spent the
+// leaf); it's stamped onto the migrated entry only when the body being
+// migrated didn't already carry a BlockHash, and is ignored for every other
+// version pair. Leaving it nil when it's needed returns an error rather than
+// silently migrating an entry with no BlockHash.
+//
+// Likewise, migrating a LeafDataVersion3 body down to version 1 or 2 requires
+// an OutPoint, which the compressed body never carries either; serializeV1
+// (and serializeV2, which builds on it) reports that case as an error rather
+// than migrating an entry with no OutPoint.
+func MigrateLeafData(oldVersion, newVersion int, r io.Reader, w io.Writer, blockHash *chainhash.Hash) error {
+	var leaf LeafData
+	switch LeafDataVersion(oldVersion) {
+	case LeafDataVersion1:
+		if err := leaf.deserializeV1(r); err != nil {
+			return err
+		}
+	case LeafDataVersion2:
+		if err := leaf.deserializeV2(r); err != nil {
+			return err
+		}
+	case LeafDataVersion3:
+		if err := leaf.deserializeV3(r); err != nil {
+			return err
+		}
+	default:
+		return ErrUnknownLeafDataVersion
+	}
+
+	switch LeafDataVersion(newVersion) {
+	case LeafDataVersion1:
+		return leaf.serializeV1(w)
+	case LeafDataVersion2:
+		if leaf.BlockHash == nil {
+			leaf.BlockHash = blockHash
+		}
+		if leaf.BlockHash == nil {
+			return fmt.Errorf("cannot migrate LeafData from version %d to version 2: "+
+				"BlockHash is not recoverable from the version %d serialization and "+
+				"must be supplied via blockHash before upgrading", oldVersion, oldVersion)
+		}
+		return leaf.serializeV2(w)
+	case LeafDataVersion3:
+		return leaf.serializeV3(w)
+	default:
+		return ErrUnknownLeafDataVersion
+	}
+}
+
+// LeafDataEntry pairs the old serialized body of a single LeafData with the
+// writer its migrated body should land in.  It's the unit of work driven by
+// MigrateLeafDataBatch.
+type LeafDataEntry struct {
+	Reader io.Reader
+	Writer io.Writer
+
+	// BlockHash is forwarded to MigrateLeafData as the BlockHash to
+	// stamp onto this entry when upgrading to LeafDataVersion2 from a
+	// version that doesn't carry one. It may be left nil for every other
+	// version pair, or when the entry's own body already carries one.
+	BlockHash *chainhash.Hash
+}
+
+// MigrateLeafDataBatch migrates every entry in entries from oldVersion to
+// newVersion with MigrateLeafData, modeled on btcd's migrateBlockIndex.
+// interrupt is checked between entries so a caller driving a long-running
+// migration of an existing utreexo proof store -- which may hold millions of
+// leaves -- can cancel cleanly; entries already migrated before the signal is
+// observed are left in place.
+func MigrateLeafDataBatch(oldVersion, newVersion int, entries []LeafDataEntry, interrupt <-chan struct{}) error {
+	for i, entry := range entries {
+		if interruptRequested(interrupt) {
+			return fmt.Errorf("leaf data migration interrupted after migrating %d of %d entries",
+				i, len(entries))
+		}
+
+		if err := MigrateLeafData(oldVersion, newVersion, entry.Reader, entry.Writer, entry.BlockHash); err != nil {
+			return fmt.Errorf("failed to migrate leaf data entry %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// interruptRequested returns true if interrupt has been signaled without
+// blocking when it hasn't.
+func interruptRequested(interrupt <-chan struct{}) bool {
+	select {
+	case <-interrupt:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2021 The utreexo developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// TestLeafHashStableAcrossVersionBump checks that LeafHash always hashes the
+// version 1 serialization, regardless of LatestLeafDataVersion, so that
+// bumping the on-disk/wire format never shifts an existing accumulator
+// commitment.
+func TestLeafHashStableAcrossVersionBump(t *testing.T) {
+	leaf := LeafData{
+		BlockHash:  &chainhash.Hash{0x01},
+		OutPoint:   &OutPoint{Hash: chainhash.Hash{0x02}, Index: 7},
+		Height:     500000,
+		IsCoinBase: false,
+		Amount:     123456789,
+		PkScript:   []byte{0x76, 0xa9},
+	}
+
+	want := leaf.LeafHash()
+
+	// LeafHash must match hashing the version 1 body directly, even
+	// though Serialize now defaults to LatestLeafDataVersion.
+	var v1Buf bytes.Buffer
+	if err := leaf.serializeV1(&v1Buf); err != nil {
+		t.Fatalf("serializeV1: %v", err)
+	}
+	digest := sha512.Sum512_256(v1Buf.Bytes())
+	if digest != want {
+		t.Errorf("LeafHash diverged from the version 1 serialization: got %x, want %x",
+			want, digest)
+	}
+
+	// Changing only the BlockHash -- which the version 1 body never
+	// serializes -- must not change LeafHash.
+	other := leaf
+	otherHash := chainhash.Hash{0xff}
+	other.BlockHash = &otherHash
+	if other.LeafHash() != want {
+		t.Error("LeafHash changed when only BlockHash (absent from the version 1 body) changed")
+	}
+}
+
+// TestMigrateLeafData checks MigrateLeafData across a set of version pairs,
+// including the LeafDataVersion1 -> LeafDataVersion2 upgrade that needs a
+// BlockHash supplied out of band, migrations from LeafDataVersion3 that lack
+// an OutPoint, and an unknown version.
+func TestMigrateLeafData(t *testing.T) {
+	blockHash := chainhash.Hash{0x42}
+	base := LeafData{
+		OutPoint:   &OutPoint{Hash: chainhash.Hash{0x13}, Index: 3},
+		Height:     12345,
+		IsCoinBase: true,
+		Amount:     987654321,
+		PkScript:   []byte{0x51, 0x52},
+	}
+
+	serialize := func(version LeafDataVersion, leaf LeafData) []byte {
+		var buf bytes.Buffer
+		var err error
+		switch version {
+		case LeafDataVersion1:
+			err = leaf.serializeV1(&buf)
+		case LeafDataVersion2:
+			err = leaf.serializeV2(&buf)
+		case LeafDataVersion3:
+			err = leaf.serializeV3(&buf)
+		}
+		if err != nil {
+			t.Fatalf("serialize version %d: %v", version, err)
+		}
+		return buf.Bytes()
+	}
+
+	deserialize := func(version LeafDataVersion, body []byte) LeafData {
+		var leaf LeafData
+		var err error
+		r := bytes.NewReader(body)
+		switch version {
+		case LeafDataVersion1:
+			err = leaf.deserializeV1(r)
+		case LeafDataVersion2:
+			err = leaf.deserializeV2(r)
+		case LeafDataVersion3:
+			err = leaf.deserializeV3(r)
+		}
+		if err != nil {
+			t.Fatalf("deserialize version %d: %v", version, err)
+		}
+		return leaf
+	}
+
+	t.Run("v1 to v2 without a BlockHash fails", func(t *testing.T) {
+		body := serialize(LeafDataVersion1, base)
+		var out bytes.Buffer
+		err := MigrateLeafData(int(LeafDataVersion1), int(LeafDataVersion2),
+			bytes.NewReader(body), &out, nil)
+		if err == nil {
+			t.Fatal("expected an error, got nil")
+		}
+	})
+
+	t.Run("v1 to v2 with a supplied BlockHash succeeds", func(t *testing.T) {
+		body := serialize(LeafDataVersion1, base)
+		var out bytes.Buffer
+		err := MigrateLeafData(int(LeafDataVersion1), int(LeafDataVersion2),
+			bytes.NewReader(body), &out, &blockHash)
+		if err != nil {
+			t.Fatalf("MigrateLeafData: %v", err)
+		}
+
+		migrated := deserialize(LeafDataVersion2, out.Bytes())
+		if migrated.BlockHash == nil || *migrated.BlockHash != blockHash {
+			t.Errorf("BlockHash mismatch: got %v, want %v", migrated.BlockHash, blockHash)
+		}
+		if migrated.Height != base.Height || migrated.Amount != base.Amount ||
+			!bytes.Equal(migrated.PkScript, base.PkScript) {
+			t.Errorf("migrated body mismatch: got %+v, want %+v fields carried over from %+v",
+				migrated, base, base)
+		}
+	})
+
+	t.Run("v2 to v1 drops the BlockHash", func(t *testing.T) {
+		withHash := base
+		withHash.BlockHash = &blockHash
+		body := serialize(LeafDataVersion2, withHash)
+
+		var out bytes.Buffer
+		if err := MigrateLeafData(int(LeafDataVersion2), int(LeafDataVersion1),
+			bytes.NewReader(body), &out, nil); err != nil {
+			t.Fatalf("MigrateLeafData: %v", err)
+		}
+
+		migrated := deserialize(LeafDataVersion1, out.Bytes())
+		if migrated.BlockHash != nil {
+			t.Errorf("expected BlockHash to be dropped, got %v", migrated.BlockHash)
+		}
+	})
+
+	t.Run("v2 to v3 succeeds without a supplied BlockHash", func(t *testing.T) {
+		withHash := base
+		withHash.BlockHash = &blockHash
+		body := serialize(LeafDataVersion2, withHash)
+
+		var out bytes.Buffer
+		if err := MigrateLeafData(int(LeafDataVersion2), int(LeafDataVersion3),
+			bytes.NewReader(body), &out, nil); err != nil {
+			t.Fatalf("MigrateLeafData: %v", err)
+		}
+
+		migrated := deserialize(LeafDataVersion3, out.Bytes())
+		if migrated.Amount != base.Amount || !bytes.Equal(migrated.PkScript, base.PkScript) {
+			t.Errorf("migrated body mismatch: got %+v, want fields from %+v", migrated, base)
+		}
+	})
+
+	t.Run("v3 to v1 without an OutPoint fails instead of panicking", func(t *testing.T) {
+		// deserializeV3 never recovers OutPoint, so migrating down to a
+		// body that needs one must error rather than dereference a nil
+		// pointer.
+		noOutPoint := base
+		noOutPoint.OutPoint = nil
+		body := serialize(LeafDataVersion3, noOutPoint)
+
+		var out bytes.Buffer
+		if err := MigrateLeafData(int(LeafDataVersion3), int(LeafDataVersion1),
+			bytes.NewReader(body), &out, nil); err == nil {
+			t.Fatal("expected an error, got nil")
+		}
+	})
+
+	t.Run("v3 to v2 without an OutPoint fails instead of panicking", func(t *testing.T) {
+		noOutPoint := base
+		noOutPoint.OutPoint = nil
+		body := serialize(LeafDataVersion3, noOutPoint)
+
+		var out bytes.Buffer
+		if err := MigrateLeafData(int(LeafDataVersion3), int(LeafDataVersion2),
+			bytes.NewReader(body), &out, &blockHash); err == nil {
+			t.Fatal("expected an error, got nil")
+		}
+	})
+
+	t.Run("unknown version is rejected", func(t *testing.T) {
+		body := serialize(LeafDataVersion1, base)
+		var out bytes.Buffer
+		if err := MigrateLeafData(99, int(LeafDataVersion1),
+			bytes.NewReader(body), &out, nil); err != ErrUnknownLeafDataVersion {
+			t.Errorf("got %v, want %v", err, ErrUnknownLeafDataVersion)
+		}
+	})
+}
+
+// TestMigrateLeafDataBatch checks that MigrateLeafDataBatch forwards each
+// entry's BlockHash through to MigrateLeafData.
+func TestMigrateLeafDataBatch(t *testing.T) {
+	blockHash := chainhash.Hash{0x07}
+	leaf := LeafData{
+		OutPoint: &OutPoint{Hash: chainhash.Hash{0x21}, Index: 9},
+		Height:   1,
+		Amount:   1,
+		PkScript: []byte{0x51},
+	}
+
+	var body bytes.Buffer
+	if err := leaf.serializeV1(&body); err != nil {
+		t.Fatalf("serializeV1: %v", err)
+	}
+
+	var out bytes.Buffer
+	entries := []LeafDataEntry{
+		{Reader: bytes.NewReader(body.Bytes()), Writer: &out, BlockHash: &blockHash},
+	}
+
+	if err := MigrateLeafDataBatch(int(LeafDataVersion1), int(LeafDataVersion2),
+		entries, nil); err != nil {
+		t.Fatalf("MigrateLeafDataBatch: %v", err)
+	}
+
+	var migrated LeafData
+	if err := migrated.deserializeV2(bytes.NewReader(out.Bytes())); err != nil {
+		t.Fatalf("deserializeV2: %v", err)
+	}
+	if migrated.BlockHash == nil || *migrated.BlockHash != blockHash {
+		t.Errorf("BlockHash mismatch: got %v, want %v", migrated.BlockHash, blockHash)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2021 The utreexo developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+)
+
+// CmdUtreexoUndoBlock is the command string used in the message header for
+// the utreexo undo block message, following the naming of the other Cmd*
+// command strings.
+const CmdUtreexoUndoBlock = "utreexoundo"
+
+// minLeafDataCompactSize is a conservative lower bound on the number of
+// bytes a single compact LeafData entry can take up on the wire (its
+// version, header code, amount, and pkscript length VLQs never serialize to
+// fewer bytes than this).  It's used to bound txCount and spentCount against
+// MaxBlockPayload before BlockLeafData.Deserialize allocates off them.
+const minLeafDataCompactSize = 4
+
+// maxLeavesPerBlock bounds the number of per-tx entries
+// BlockLeafData.Deserialize will allocate for, mirroring how MsgBlock bounds
+// its own transaction count against MaxBlockPayload before allocating.
+const maxLeavesPerBlock = MaxBlockPayload / minLeafDataCompactSize
+
+// maxSpentPerTx bounds the number of per-tx spent inputs
+// BlockLeafData.Deserialize will allocate for, mirroring how MsgTx bounds
+// its own TxIn count against the maximum payload size before allocating.
+const maxSpentPerTx = MaxBlockPayload / minLeafDataCompactSize
+
+// BlockLeafData groups the compact LeafData for every input spent by a
+// block, indexed by transaction.  Leaves[i] holds one compact LeafData per
+// input of block.Transactions[i], in input order; a coinbase transaction
+// spends nothing, so it always contributes an empty slice.
+//
+// It's the wire-layer counterpart of a spend-journal bucket entry in btcd's
+// blockchain database: a single blob a node can store per block and hand to
+// a peer doing reorg-driven UTXO reconstruction, instead of making the
+// caller invent its own per-leaf framing.
+type BlockLeafData struct {
+	Leaves [][]LeafData
+}
+
+// -----------------------------------------------------------------------------
+// BlockLeafData serialization packages the compact LeafData spent by every
+// transaction in a block into a single blob.
+//
+// The serialized format is:
+// [<tx count><per-tx spent inputs>...]
+//
+// Field              Type       Size
+// tx count           VLQ        variable
+// per-tx             -          variable
+//   spent input count VLQ        variable
+//   compact LeafData -          variable (one per spent input)
+//
+// -----------------------------------------------------------------------------
+
+// SerializeSize returns the number of bytes it would take to serialize the
+// BlockLeafData.
+func (b *BlockLeafData) SerializeSize() int {
+	size := VarIntSerializeSize(uint64(len(b.Leaves)))
+	for _, leaves := range b.Leaves {
+		size += VarIntSerializeSize(uint64(len(leaves)))
+		for i := range leaves {
+			size += leaves[i].SerializeSizeCompact()
+		}
+	}
+	return size
+}
+
+// Serialize encodes the BlockLeafData to w using the BlockLeafData
+// serialization format.
+func (b *BlockLeafData) Serialize(w io.Writer) error {
+	if err := WriteVarInt(w, 0, uint64(len(b.Leaves))); err != nil {
+		return err
+	}
+
+	for _, leaves := range b.Leaves {
+		if err := WriteVarInt(w, 0, uint64(len(leaves))); err != nil {
+			return err
+		}
+		for i := range leaves {
+			if err := leaves[i].SerializeCompact(w); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Deserialize decodes the BlockLeafData from r using the BlockLeafData
+// serialization format.
+func (b *BlockLeafData) Deserialize(r io.Reader) error {
+	txCount, err := ReadVarInt(r, 0)
+	if err != nil {
+		return err
+	}
+	if txCount > maxLeavesPerBlock {
+		str := fmt.Sprintf("too many transactions in BlockLeafData "+
+			"[count %d, max %d]", txCount, maxLeavesPerBlock)
+		return messageError("BlockLeafData.Deserialize", str)
+	}
+
+	leaves := make([][]LeafData, txCount)
+	for i := range leaves {
+		spentCount, err := ReadVarInt(r, 0)
+		if err != nil {
+			return err
+		}
+		if spentCount > maxSpentPerTx {
+			str := fmt.Sprintf("too many spent inputs for tx %d in "+
+				"BlockLeafData [count %d, max %d]", i, spentCount, maxSpentPerTx)
+			return messageError("BlockLeafData.Deserialize", str)
+		}
+
+		txLeaves := make([]LeafData, spentCount)
+		for j := range txLeaves {
+			if err := txLeaves[j].DeserializeCompact(r); err != nil {
+				return err
+			}
+		}
+		leaves[i] = txLeaves
+	}
+
+	b.Leaves = leaves
+	return nil
+}
+
+// ToLeafDatas reconstructs the full LeafData -- including OutPoint and
+// BlockHash, which the compact serialization leaves out because they're
+// recoverable from the block -- for every input spent by block, by walking
+// block's transactions in lockstep with b.Leaves.  block must be the same
+// block b was built from; a mismatched transaction or input count is
+// reported as an error rather than silently misaligning the two.
+func (b *BlockLeafData) ToLeafDatas(block *MsgBlock) ([]LeafData, error) {
+	if len(b.Leaves) != len(block.Transactions) {
+		return nil, fmt.Errorf("BlockLeafData has leaves for %d transactions but "+
+			"block has %d", len(b.Leaves), len(block.Transactions))
+	}
+
+	blockHash := block.BlockHash()
+
+	var leafDatas []LeafData
+	for i, tx := range block.Transactions {
+		txLeaves := b.Leaves[i]
+
+		// The coinbase transaction spends nothing.
+		if i == 0 {
+			if len(txLeaves) != 0 {
+				return nil, fmt.Errorf("coinbase transaction must not contribute leaf data")
+			}
+			continue
+		}
+
+		if len(txLeaves) != len(tx.TxIn) {
+			return nil, fmt.Errorf("tx %d: have leaf data for %d inputs but "+
+				"the transaction has %d", i, len(txLeaves), len(tx.TxIn))
+		}
+
+		for j, txIn := range tx.TxIn {
+			leaf := txLeaves[j]
+			leaf.BlockHash = &blockHash
+			outpoint := txIn.PreviousOutPoint
+			leaf.OutPoint = &outpoint
+			leafDatas = append(leafDatas, leaf)
+		}
+	}
+
+	return leafDatas, nil
+}
+
+// MsgUtreexoUndoBlock implements the Message interface and represents a
+// utreexo undo block message, which carries the compact LeafData for every
+// input spent by a block in a single unit, following the pattern of btcd's
+// spendjournal bucket entries.
+type MsgUtreexoUndoBlock struct {
+	BlockLeafData BlockLeafData
+}
+
+// BtcDecode decodes r using the wire protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgUtreexoUndoBlock) BtcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	return msg.BlockLeafData.Deserialize(r)
+}
+
+// BtcEncode encodes the receiver to w using the wire protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgUtreexoUndoBlock) BtcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	return msg.BlockLeafData.Serialize(w)
+}
+
+// Command returns the protocol command string for the message.  This is
+// part of the Message interface implementation.
+func (msg *MsgUtreexoUndoBlock) Command() string {
+	return CmdUtreexoUndoBlock
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgUtreexoUndoBlock) MaxPayloadLength(pver uint32) uint32 {
+	return MaxBlockPayload
+}
+
+// NewMsgUtreexoUndoBlock returns a new utreexo undo block message that
+// conforms to the Message interface using the passed parameters and
+// defaults for the remaining fields.
+func NewMsgUtreexoUndoBlock(blockLeafData BlockLeafData) *MsgUtreexoUndoBlock {
+	return &MsgUtreexoUndoBlock{BlockLeafData: blockLeafData}
+}
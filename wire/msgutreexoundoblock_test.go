@@ -0,0 +1,165 @@
+// Copyright (c) 2021 The utreexo developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// TestBlockLeafDataSerializeRoundTrip checks Serialize/Deserialize round-trip
+// a BlockLeafData covering a coinbase-only transaction and a transaction
+// spending multiple inputs.
+func TestBlockLeafDataSerializeRoundTrip(t *testing.T) {
+	bld := BlockLeafData{
+		Leaves: [][]LeafData{
+			{}, // coinbase spends nothing.
+			{
+				{Height: 100, Amount: 5000000000, PkScript: []byte{0x6a}},
+				{Height: 200, IsCoinBase: true, Amount: 1, PkScript: []byte{}},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := bld.Serialize(&buf); err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+
+	if got, want := buf.Len(), bld.SerializeSize(); got != want {
+		t.Errorf("SerializeSize mismatch: got %d, want %d", got, want)
+	}
+
+	var got BlockLeafData
+	if err := got.Deserialize(&buf); err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+
+	if len(got.Leaves) != len(bld.Leaves) {
+		t.Fatalf("leaf count mismatch: got %d, want %d", len(got.Leaves), len(bld.Leaves))
+	}
+	for i := range bld.Leaves {
+		if len(got.Leaves[i]) != len(bld.Leaves[i]) {
+			t.Fatalf("tx %d: leaf count mismatch: got %d, want %d",
+				i, len(got.Leaves[i]), len(bld.Leaves[i]))
+		}
+		for j := range bld.Leaves[i] {
+			want := bld.Leaves[i][j]
+			have := got.Leaves[i][j]
+			if have.Height != want.Height || have.IsCoinBase != want.IsCoinBase ||
+				have.Amount != want.Amount || !bytes.Equal(have.PkScript, want.PkScript) {
+				t.Errorf("tx %d leaf %d mismatch: got %+v, want %+v", i, j, have, want)
+			}
+		}
+	}
+}
+
+// TestBlockLeafDataDeserializeRejectsHugeCounts ensures a peer can't crash
+// the node with a tiny payload encoding a huge txCount or spentCount: both
+// must be rejected before BlockLeafData.Deserialize allocates off them.
+func TestBlockLeafDataDeserializeRejectsHugeCounts(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{
+			name: "huge txCount",
+			buf:  mustHexDecode("ffffffffffffffffff"), // VarInt-encoded ~2^64-1
+		},
+		{
+			name: "huge spentCount",
+			// txCount = 1, followed by a huge spentCount.
+			buf: append([]byte{0x01}, mustHexDecode("ffffffffffffffffff")...),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got BlockLeafData
+			err := got.Deserialize(bytes.NewReader(test.buf))
+			if err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+		})
+	}
+}
+
+// TestMsgUtreexoUndoBlockWireRoundTrip checks MsgUtreexoUndoBlock's
+// BtcEncode/BtcDecode round-trip, along with its Command/MaxPayloadLength.
+func TestMsgUtreexoUndoBlockWireRoundTrip(t *testing.T) {
+	msg := NewMsgUtreexoUndoBlock(BlockLeafData{
+		Leaves: [][]LeafData{
+			{},
+			{{Height: 42, Amount: 1000, PkScript: []byte{0x51}}},
+		},
+	})
+
+	if cmd := msg.Command(); cmd != CmdUtreexoUndoBlock {
+		t.Errorf("Command: got %s, want %s", cmd, CmdUtreexoUndoBlock)
+	}
+	if want, got := uint32(MaxBlockPayload), msg.MaxPayloadLength(0); got != want {
+		t.Errorf("MaxPayloadLength: got %d, want %d", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, 0, BaseEncoding); err != nil {
+		t.Fatalf("BtcEncode: %v", err)
+	}
+
+	var got MsgUtreexoUndoBlock
+	if err := got.BtcDecode(&buf, 0, BaseEncoding); err != nil {
+		t.Fatalf("BtcDecode: %v", err)
+	}
+
+	if len(got.BlockLeafData.Leaves) != len(msg.BlockLeafData.Leaves) {
+		t.Fatalf("leaf count mismatch: got %d, want %d",
+			len(got.BlockLeafData.Leaves), len(msg.BlockLeafData.Leaves))
+	}
+}
+
+// TestBlockLeafDataToLeafDatas checks that ToLeafDatas reconstructs the
+// OutPoint and BlockHash left out of the compact serialization from the
+// block it's paired with, and rejects a mismatched block.
+func TestBlockLeafDataToLeafDatas(t *testing.T) {
+	txIn := TxIn{PreviousOutPoint: OutPoint{Hash: chainhash.Hash{0x01}, Index: 1}}
+	block := &MsgBlock{
+		Transactions: []*MsgTx{
+			{TxIn: []*TxIn{}}, // coinbase.
+			{TxIn: []*TxIn{&txIn}},
+		},
+	}
+
+	bld := BlockLeafData{
+		Leaves: [][]LeafData{
+			{},
+			{{Height: 10, Amount: 5, PkScript: []byte{0x51}}},
+		},
+	}
+
+	leafDatas, err := bld.ToLeafDatas(block)
+	if err != nil {
+		t.Fatalf("ToLeafDatas: %v", err)
+	}
+	if len(leafDatas) != 1 {
+		t.Fatalf("got %d leaf datas, want 1", len(leafDatas))
+	}
+
+	wantHash := block.BlockHash()
+	if leafDatas[0].BlockHash == nil || *leafDatas[0].BlockHash != wantHash {
+		t.Errorf("BlockHash mismatch: got %v, want %v", leafDatas[0].BlockHash, wantHash)
+	}
+	if leafDatas[0].OutPoint == nil || *leafDatas[0].OutPoint != txIn.PreviousOutPoint {
+		t.Errorf("OutPoint mismatch: got %v, want %v", leafDatas[0].OutPoint, txIn.PreviousOutPoint)
+	}
+
+	// A block with the wrong number of transactions must be rejected
+	// rather than silently misaligning leaves to transactions.
+	mismatched := &MsgBlock{Transactions: []*MsgTx{{TxIn: []*TxIn{}}}}
+	if _, err := bld.ToLeafDatas(mismatched); err == nil {
+		t.Error("expected an error for a transaction-count mismatch, got nil")
+	}
+}